@@ -0,0 +1,225 @@
+// Package memcachedstore provides a memcached-backed GCRAStore
+// implementation for throttled, as an alternative to the in-memory and
+// Redis-based stores.
+package memcachedstore // import "gopkg.in/throttled/throttled.v0/store/memcachedstore"
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/hashicorp/golang-lru"
+
+	"gopkg.in/throttled/throttled.v0/store"
+)
+
+// numTokenShards is the number of shards used to store the CAS tokens
+// observed by GetWithTime, keyed by (key, value) pair, so that concurrent
+// callers operating on different keys don't contend for the same lock.
+const numTokenShards = 16
+
+// tokensPerShard bounds each shard's CAS-token cache with an LRU eviction
+// policy, so that reads never consumed by a matching CompareAndSwap (a
+// failed or raced swap, a retry that observes a newer value, or a read used
+// only for inspection) don't grow the cache without bound.
+const tokensPerShard = 256
+
+// casRetries bounds the Gets+compare+Cas loop that CompareAndSwap falls
+// back to when it has no stashed CAS token for the (key, old) pair, or when
+// the stashed token has gone stale.
+const casRetries = 3
+
+// memcachedStore implements store.GCRAStore on top of a memcached client,
+// allowing rate limiter state to be shared across processes.
+type memcachedStore struct {
+	client *memcache.Client
+	tokens [numTokenShards]tokenShard
+}
+
+// tokenShard caches the *memcache.Item most recently observed by
+// GetWithTime for a given (key, value) pair. memcache.Item carries its CAS
+// id internally, so stashing the item itself lets a later CompareAndSwap
+// call memcache.Client.CompareAndSwap directly instead of performing its
+// own Gets round-trip. The cache is LRU-bounded so that tokens nobody ever
+// consumes eventually fall out instead of leaking.
+type tokenShard struct {
+	sync.Mutex
+	keys *lru.Cache
+}
+
+// New creates a new store.GCRAStore backed by the given memcached client.
+//
+// Memcached does not expose a server-side clock, so GetWithTime returns the
+// calling process's local time rather than the server's. GCRA assumes that
+// all instances sharing the same store also share the same clock; as the
+// store.GCRAStore documentation notes, this works if the skew between callers
+// is small, but is not recommended in practice if it isn't.
+func New(client *memcache.Client) store.GCRAStore {
+	ms := &memcachedStore{client: client}
+
+	for i := range ms.tokens {
+		keys, err := lru.New(tokensPerShard)
+		if err != nil {
+			// Only returns an error if tokensPerShard <= 0.
+			panic(err)
+		}
+		ms.tokens[i].keys = keys
+	}
+
+	return ms
+}
+
+func (ms *memcachedStore) GetWithTime(key string) (int64, time.Time, error) {
+	now := time.Now()
+
+	item, err := ms.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return -1, now, nil
+	} else if err != nil {
+		return 0, now, err
+	}
+
+	val, err := strconv.ParseInt(string(item.Value), 10, 64)
+	if err != nil {
+		return 0, now, err
+	}
+
+	ms.putToken(key, val, item)
+
+	return val, now, nil
+}
+
+func (ms *memcachedStore) SetIfNotExists(key string, value int64, ttl time.Duration) (bool, error) {
+	item := &memcache.Item{
+		Key:        key,
+		Value:      []byte(strconv.FormatInt(value, 10)),
+		Expiration: ttlSeconds(ttl),
+	}
+
+	err := ms.client.Add(item)
+	if err == memcache.ErrNotStored {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (ms *memcachedStore) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	newVal := []byte(strconv.FormatInt(new, 10))
+	exp := ttlSeconds(ttl)
+
+	if item, ok := ms.takeToken(key, old); ok {
+		item.Value = newVal
+		item.Expiration = exp
+
+		switch err := ms.client.CompareAndSwap(item); err {
+		case nil:
+			return true, nil
+		case memcache.ErrCASConflict, memcache.ErrNotStored:
+			// Fall through to the Gets+compare+Cas loop below, in case the
+			// stashed token was stale rather than the key being gone.
+		default:
+			return false, err
+		}
+	}
+
+	for i := 0; i < casRetries; i++ {
+		item, err := ms.client.Get(key)
+		if err == memcache.ErrCacheMiss {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		curVal, err := strconv.ParseInt(string(item.Value), 10, 64)
+		if err != nil {
+			return false, err
+		}
+		if curVal != old {
+			return false, nil
+		}
+
+		item.Value = newVal
+		item.Expiration = exp
+
+		switch err := ms.client.CompareAndSwap(item); err {
+		case nil:
+			return true, nil
+		case memcache.ErrCASConflict:
+			continue
+		case memcache.ErrNotStored:
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// putToken stashes item, keyed by (key, value), for a later CompareAndSwap
+// to consume. If the shard is full, the least recently used token is
+// evicted to make room.
+func (ms *memcachedStore) putToken(key string, value int64, item *memcache.Item) {
+	shard := ms.shardFor(key, value)
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	shard.keys.Add(tokenKey(key, value), item)
+}
+
+// takeToken returns and removes the item stashed for (key, value), if any.
+// It is removed whether or not it ends up being used, since it is only
+// ever valid for a single CompareAndSwap attempt.
+func (ms *memcachedStore) takeToken(key string, value int64) (*memcache.Item, bool) {
+	shard := ms.shardFor(key, value)
+	tk := tokenKey(key, value)
+
+	shard.Lock()
+	defer shard.Unlock()
+
+	itemI, ok := shard.keys.Get(tk)
+	if !ok {
+		return nil, false
+	}
+	shard.keys.Remove(tk)
+
+	return itemI.(*memcache.Item), true
+}
+
+func (ms *memcachedStore) shardFor(key string, value int64) *tokenShard {
+	h := fnv.New32a()
+	h.Write([]byte(tokenKey(key, value)))
+
+	return &ms.tokens[h.Sum32()%numTokenShards]
+}
+
+func tokenKey(key string, value int64) string {
+	return fmt.Sprintf("%s\x00%d", key, value)
+}
+
+// thirtyDays is the boundary past which memcached treats an item's
+// Expiration field as an absolute Unix timestamp rather than a number of
+// seconds from now.
+const thirtyDays = 30 * 24 * time.Hour
+
+// ttlSeconds rounds ttl to the nearest second, as required by memcached's
+// expiration field. If ttl exceeds memcached's 30-day relative-expiration
+// boundary, it is instead converted to an absolute Unix timestamp, since
+// memcached would otherwise interpret the raw seconds count as one and
+// expire the item immediately.
+func ttlSeconds(ttl time.Duration) int32 {
+	ttl = ttl.Round(time.Second)
+
+	if ttl > thirtyDays {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+
+	return int32(ttl / time.Second)
+}
@@ -0,0 +1,108 @@
+package memcachedstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// newTestStore connects to the memcached server at MEMCACHED_ADDR, skipping
+// the test if it isn't set so that `go test ./...` doesn't require a
+// running memcached by default.
+func newTestStore(t *testing.T) *memcachedStore {
+	addr := os.Getenv("MEMCACHED_ADDR")
+	if addr == "" {
+		t.Skip("set MEMCACHED_ADDR to run memcachedstore integration tests")
+	}
+
+	client := memcache.New(addr)
+	if err := client.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(client).(*memcachedStore)
+}
+
+func TestGetWithTimeNoSuchKey(t *testing.T) {
+	s := newTestStore(t)
+
+	val, _, err := s.GetWithTime("does-not-exist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != -1 {
+		t.Errorf("expected -1, got %d", val)
+	}
+}
+
+func TestSetIfNotExists(t *testing.T) {
+	s := newTestStore(t)
+
+	ok, err := s.SetIfNotExists("key", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected key to be set")
+	}
+
+	ok, err = s.SetIfNotExists("key", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected key to already exist")
+	}
+
+	val, _, err := s.GetWithTime("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != 1 {
+		t.Errorf("expected 1, got %d", val)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	s := newTestStore(t)
+
+	if ok, err := s.CompareAndSwap("key", 0, 1, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected swap to fail on a key that doesn't exist")
+	}
+
+	if _, err := s.SetIfNotExists("key", 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.GetWithTime("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := s.CompareAndSwap("key", 0, 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected swap to succeed")
+	}
+
+	val, _, err := s.GetWithTime("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != 1 {
+		t.Errorf("expected 1, got %d", val)
+	}
+
+	ok, err = s.CompareAndSwap("key", 0, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected swap against a stale old value to fail")
+	}
+}
@@ -0,0 +1,122 @@
+package memcachedstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// These tests exercise the package's pure, in-memory logic directly, so
+// they run under a plain `go test` without a live memcached server.
+// memcache.New only constructs a client; it doesn't dial until a command is
+// issued, so it's safe to use here even though nothing is listening.
+
+func TestTTLSeconds(t *testing.T) {
+	cases := []struct {
+		name string
+		ttl  time.Duration
+		want int32
+	}{
+		{"zero", 0, 0},
+		{"whole seconds", 90 * time.Second, 90},
+		{"rounds to nearest second", 90*time.Second + 600*time.Millisecond, 91},
+		{"at the 30-day boundary", thirtyDays, int32(thirtyDays / time.Second)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ttlSeconds(c.ttl); got != c.want {
+				t.Errorf("ttlSeconds(%s) = %d, want %d", c.ttl, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTTLSecondsPastThirtyDayBoundary(t *testing.T) {
+	ttl := thirtyDays + time.Hour
+
+	before := time.Now().Add(ttl).Unix()
+	got := ttlSeconds(ttl)
+	after := time.Now().Add(ttl).Unix()
+
+	// ttlSeconds must switch to an absolute Unix timestamp once ttl exceeds
+	// memcached's 30-day relative-expiration boundary, rather than the raw
+	// (and by then nonsensical) seconds count.
+	if int64(got) < before || int64(got) > after {
+		t.Errorf("ttlSeconds(%s) = %d, want an absolute timestamp in [%d, %d]", ttl, got, before, after)
+	}
+}
+
+func TestPutTokenTakeToken(t *testing.T) {
+	ms := New(memcache.New("ignored")).(*memcachedStore)
+
+	item := &memcache.Item{Key: "key", Value: []byte("1")}
+	ms.putToken("key", 1, item)
+
+	got, ok := ms.takeToken("key", 1)
+	if !ok {
+		t.Fatal("expected a stashed token")
+	}
+	if got != item {
+		t.Error("expected takeToken to return the exact item passed to putToken")
+	}
+
+	// takeToken removes the token, so a second call must miss.
+	if _, ok := ms.takeToken("key", 1); ok {
+		t.Fatal("expected the token to be consumed by the first takeToken")
+	}
+}
+
+func TestTakeTokenMiss(t *testing.T) {
+	ms := New(memcache.New("ignored")).(*memcachedStore)
+
+	if _, ok := ms.takeToken("missing", 0); ok {
+		t.Fatal("expected no token to be stashed")
+	}
+}
+
+func TestTakeTokenDistinguishesValue(t *testing.T) {
+	ms := New(memcache.New("ignored")).(*memcachedStore)
+
+	ms.putToken("key", 1, &memcache.Item{Key: "key", Value: []byte("1")})
+
+	// A token stashed for value 1 must not be returned for a lookup of
+	// value 2 on the same key: old is part of the cache key.
+	if _, ok := ms.takeToken("key", 2); ok {
+		t.Fatal("expected no token for a different value")
+	}
+	if _, ok := ms.takeToken("key", 1); !ok {
+		t.Fatal("expected the token stashed for value 1 to still be there")
+	}
+}
+
+func TestTokenShardEviction(t *testing.T) {
+	ms := New(memcache.New("ignored")).(*memcachedStore)
+
+	// Operate directly on a single shard's LRU cache so the test isn't at
+	// the mercy of which shard shardFor picks for a given (key, value).
+	shard := &ms.tokens[0]
+	for i := 0; i < tokensPerShard+1; i++ {
+		shard.keys.Add(tokenKey("key", int64(i)), &memcache.Item{})
+	}
+
+	// Filling a shard past its capacity must evict the least recently used
+	// token rather than growing unboundedly.
+	if n := shard.keys.Len(); n > tokensPerShard {
+		t.Errorf("shard holds %d tokens, want at most %d", n, tokensPerShard)
+	}
+
+	if _, ok := shard.keys.Get(tokenKey("key", 0)); ok {
+		t.Error("expected the oldest token to have been evicted")
+	}
+}
+
+func TestTokenKeyDistinguishesKeyAndValue(t *testing.T) {
+	if tokenKey("a", 12) == tokenKey("a1", 2) {
+		t.Error("expected tokenKey to not collide across the key/value boundary")
+	}
+	if tokenKey("a", 1) == tokenKey("a", 2) {
+		t.Error("expected tokenKey to differ for different values")
+	}
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+// shardedMemStore implements a GCRAStore backed by a fixed number of
+// independently-locked shards, so that operations on different keys never
+// contend with each other.
+type shardedMemStore struct {
+	shards []*memShard
+	mask   uint32
+}
+
+// memShard is a single shard of a shardedMemStore. Each shard owns its own
+// lock and its own key space, either an unbounded map or an LRU-bounded one,
+// exactly like memStore does for the whole store.
+type memShard struct {
+	sync.RWMutex
+	keys *lru.Cache
+	m    map[string]*int64
+}
+
+// NewShardedMemStore creates a new GCRAStore distributing its keys across
+// shards independent maps (or LRU caches), each guarded by its own lock, to
+// avoid the single-mutex contention of NewMemStore under highly concurrent
+// workloads. shards is rounded up to the next power of two so that the shard
+// for a key can be selected with a cheap mask instead of a modulo; it
+// defaults to 1 if <= 0.
+//
+// If maxKeys > 0, it is distributed evenly across the shards and each shard
+// uses an LRU algorithm to evict older keys once its share is full, exactly
+// as NewMemStore does for a single map. Because eviction happens per-shard,
+// the total number of live keys may be slightly under maxKeys; this is an
+// acceptable trade-off for lock-free-ish reads across independent keys. If
+// maxKeys is smaller than the requested (rounded) shard count, the shard
+// count is instead reduced to the largest power of two <= maxKeys, so that
+// every shard still gets at least one slot and the total capacity across
+// all shards never exceeds maxKeys.
+//
+// If maxKeys <= 0, each shard uses an unbounded map, with the same memory
+// caveats as NewMemStore.
+//
+// As with NewMemStore, this store is only for single-process rate-limiting.
+func NewShardedMemStore(maxKeys, shards int) (GCRAStore, error) {
+	if shards <= 0 {
+		shards = 1
+	}
+	shards = nextPowerOfTwo(shards)
+
+	perShard := 0
+	if maxKeys > 0 {
+		if shards > maxKeys {
+			shards = prevPowerOfTwo(maxKeys)
+		}
+		perShard = maxKeys / shards
+	}
+
+	ms := &shardedMemStore{
+		shards: make([]*memShard, shards),
+		mask:   uint32(shards - 1),
+	}
+
+	for i := range ms.shards {
+		sh := &memShard{}
+
+		if perShard > 0 {
+			keys, err := lru.New(perShard)
+			if err != nil {
+				return nil, err
+			}
+			sh.keys = keys
+		} else {
+			sh.m = make(map[string]*int64)
+		}
+
+		ms.shards[i] = sh
+	}
+
+	return ms, nil
+}
+
+func (ms *shardedMemStore) GetWithTime(key string) (int64, time.Time, error) {
+	now := time.Now()
+
+	valP, ok := ms.shardFor(key).get(key, false)
+	if !ok {
+		return -1, now, nil
+	}
+
+	return atomic.LoadInt64(valP), now, nil
+}
+
+func (ms *shardedMemStore) SetIfNotExists(key string, value int64, ttl time.Duration) (bool, error) {
+	sh := ms.shardFor(key)
+
+	if _, ok := sh.get(key, false); ok {
+		return false, nil
+	}
+
+	sh.Lock()
+	defer sh.Unlock()
+
+	if _, ok := sh.get(key, true); ok {
+		return false, nil
+	}
+
+	// Store a pointer to a new instance so that the caller
+	// can't mutate the value after setting
+	v := value
+
+	if sh.keys != nil {
+		sh.keys.Add(key, &v)
+	} else {
+		sh.m[key] = &v
+	}
+
+	return true, nil
+}
+
+func (ms *shardedMemStore) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	valP, ok := ms.shardFor(key).get(key, false)
+	if !ok {
+		return false, nil
+	}
+
+	// The CAS itself is lock-free: only the lookup above takes (or skips) the
+	// shard's lock, never the swap.
+	return atomic.CompareAndSwapInt64(valP, old, new), nil
+}
+
+func (ms *shardedMemStore) shardFor(key string) *memShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return ms.shards[h.Sum32()&ms.mask]
+}
+
+func (sh *memShard) get(key string, locked bool) (*int64, bool) {
+	var valP *int64
+	var ok bool
+
+	if sh.keys != nil {
+		var valI interface{}
+
+		valI, ok = sh.keys.Get(key)
+		if ok {
+			valP = valI.(*int64)
+		}
+	} else {
+		if !locked {
+			sh.RLock()
+			defer sh.RUnlock()
+		}
+		valP, ok = sh.m[key]
+	}
+
+	return valP, ok
+}
+
+// nextPowerOfTwo returns the smallest power of two that is >= n.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// prevPowerOfTwo returns the largest power of two that is <= n, for n >= 1.
+func prevPowerOfTwo(n int) int {
+	p := 1
+	for p*2 <= n {
+		p <<= 1
+	}
+	return p
+}
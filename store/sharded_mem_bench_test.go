@@ -0,0 +1,135 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkMemStore benchmarks the existing, single-lock NewMemStore, as a
+// baseline for BenchmarkShardedMemStore, across write mixes typical of
+// rate-limiting traffic (most requests only read+CAS an existing counter; a
+// minority create a new one via SetNX).
+func BenchmarkMemStore(b *testing.B) {
+	for _, mix := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", mix), func(b *testing.B) {
+			benchmarkMemStore(b, mix)
+		})
+	}
+}
+
+func benchmarkMemStore(b *testing.B, writePct int) {
+	s, err := NewMemStore(0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const numKeys = 1000
+	for i := 0; i < numKeys; i++ {
+		if _, err := s.SetNX(strconv.Itoa(i), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var workers int64
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine gets its own prefix so that every write creates a
+		// key that doesn't exist yet.
+		prefix := strconv.FormatInt(atomic.AddInt64(&workers, 1), 10) + "-new-"
+		n := 0
+		for pb.Next() {
+			key := strconv.Itoa(n % numKeys)
+			n++
+
+			if n%100 < writePct {
+				// Simulate a new key showing up.
+				if _, err := s.SetNX(prefix+strconv.Itoa(n), 0); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+
+			val, err := s.Get(key)
+			if err == ErrNoSuchKey {
+				continue
+			} else if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.CompareAndSwap(key, val, val+1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkShardedMemStore benchmarks NewShardedMemStore at increasing shard
+// counts against the same write mixes as BenchmarkMemStore. As the shard
+// count grows, independent keys stop contending for the same lock, at the
+// cost of a bit more memory (one lock and one map/LRU header per shard, plus
+// LRU capacity rounding when maxKeys > 0) and of eviction no longer being
+// globally LRU-accurate when maxKeys is set.
+func BenchmarkShardedMemStore(b *testing.B) {
+	writeMixes := []int{1, 10, 50}
+	shardCounts := []int{1, 4, 16, 64}
+
+	for _, mix := range writeMixes {
+		for _, shards := range shardCounts {
+			name := fmt.Sprintf("writes=%d%%/shards=%d", mix, shards)
+			b.Run(name, func(b *testing.B) {
+				benchmarkShardedMemStore(b, shards, mix)
+			})
+		}
+	}
+}
+
+func benchmarkShardedMemStore(b *testing.B, shards, writePct int) {
+	s, err := NewShardedMemStore(0, shards)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const numKeys = 1000
+	for i := 0; i < numKeys; i++ {
+		if _, err := s.SetIfNotExists(strconv.Itoa(i), 0, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var workers int64
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine gets its own prefix so that every write creates a
+		// key that doesn't exist yet.
+		prefix := strconv.FormatInt(atomic.AddInt64(&workers, 1), 10) + "-new-"
+		n := 0
+		for pb.Next() {
+			key := strconv.Itoa(n % numKeys)
+			n++
+
+			if n%100 < writePct {
+				// Simulate a new key showing up.
+				if _, err := s.SetIfNotExists(prefix+strconv.Itoa(n), 0, 0); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+
+			val, _, err := s.GetWithTime(key)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if val < 0 {
+				continue
+			}
+			if _, err := s.CompareAndSwap(key, val, val+1, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
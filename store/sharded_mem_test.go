@@ -0,0 +1,189 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedMemStoreGetWithTime(t *testing.T) {
+	s, err := NewShardedMemStore(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _, err := s.GetWithTime("missing"); err != nil {
+		t.Fatal(err)
+	} else if val != -1 {
+		t.Errorf("expected -1 for a missing key, got %d", val)
+	}
+
+	if _, err := s.SetIfNotExists("key", 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _, err := s.GetWithTime("key"); err != nil {
+		t.Fatal(err)
+	} else if val != 42 {
+		t.Errorf("expected 42, got %d", val)
+	}
+}
+
+func TestShardedMemStoreSetIfNotExists(t *testing.T) {
+	s, err := NewShardedMemStore(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := s.SetIfNotExists("key", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected key to be set")
+	}
+
+	ok, err = s.SetIfNotExists("key", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected SetIfNotExists to fail on an existing key")
+	}
+
+	if val, _, err := s.GetWithTime("key"); err != nil {
+		t.Fatal(err)
+	} else if val != 1 {
+		t.Errorf("expected the original value 1 to survive, got %d", val)
+	}
+}
+
+// TestShardedMemStoreSetIfNotExistsConcurrent exercises the double-checked
+// lookup in SetIfNotExists: when many goroutines race to set the same key,
+// exactly one of them must win.
+func TestShardedMemStoreSetIfNotExistsConcurrent(t *testing.T) {
+	s, err := NewShardedMemStore(0, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100
+	var wg sync.WaitGroup
+	var wins int64
+	var mu sync.Mutex
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if ok, err := s.SetIfNotExists("race", int64(i), time.Minute); err != nil {
+				t.Error(err)
+			} else if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Errorf("expected exactly one winner, got %d", wins)
+	}
+}
+
+func TestShardedMemStoreCompareAndSwap(t *testing.T) {
+	s, err := NewShardedMemStore(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.CompareAndSwap("key", 0, 1, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected swap against a missing key to fail")
+	}
+
+	if _, err := s.SetIfNotExists("key", 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := s.CompareAndSwap("key", 1, 2, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected swap against a stale old value to fail")
+	}
+
+	if ok, err := s.CompareAndSwap("key", 0, 2, time.Minute); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected swap against the current value to succeed")
+	}
+
+	if val, _, err := s.GetWithTime("key"); err != nil {
+		t.Fatal(err)
+	} else if val != 2 {
+		t.Errorf("expected 2, got %d", val)
+	}
+}
+
+func TestShardedMemStoreShardRounding(t *testing.T) {
+	cases := []struct {
+		maxKeys, shards int
+		wantShards      int
+	}{
+		{0, 1, 1},
+		{0, 3, 4},
+		{0, 4, 4},
+		{0, 0, 1},
+		{1, 64, 1},
+		{5, 64, 4},
+		{100, 3, 4},
+	}
+
+	for _, c := range cases {
+		s, err := NewShardedMemStore(c.maxKeys, c.shards)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ms := s.(*shardedMemStore)
+		if len(ms.shards) != c.wantShards {
+			t.Errorf("NewShardedMemStore(%d, %d): expected %d shards, got %d", c.maxKeys, c.shards, c.wantShards, len(ms.shards))
+		}
+		if ms.mask != uint32(len(ms.shards)-1) {
+			t.Errorf("NewShardedMemStore(%d, %d): mask %d does not match %d shards", c.maxKeys, c.shards, ms.mask, len(ms.shards))
+		}
+	}
+}
+
+// TestShardedMemStoreMaxKeysBound checks that the store never holds more
+// than maxKeys keys at once, even when maxKeys is smaller than the
+// requested shard count.
+func TestShardedMemStoreMaxKeysBound(t *testing.T) {
+	const maxKeys = 5
+
+	s, err := NewShardedMemStore(maxKeys, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 64; i++ {
+		if _, err := s.SetIfNotExists(string(rune('a'+i)), int64(i), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	live := 0
+	for i := 0; i < 64; i++ {
+		if val, _, err := s.GetWithTime(string(rune('a' + i))); err != nil {
+			t.Fatal(err)
+		} else if val != -1 {
+			live++
+		}
+	}
+
+	if live > maxKeys {
+		t.Errorf("expected at most %d live keys, got %d", maxKeys, live)
+	}
+}